@@ -20,6 +20,34 @@
 //			"PERSISTER_ENCRYPTION_KEY")
 //		-protocol string
 //			Network protocol to listen on (default "tcp4")
+//		-tls-cert string
+//			PEM certificate file to serve HTTPS with (requires -tls-key)
+//		-tls-key string
+//			PEM private key file matching -tls-cert
+//		-client-ca string
+//			PEM file of CA certificates to require and verify client
+//			certificates against (enables mutual TLS)
+//		-http2
+//			Configure the server for HTTP/2 (implies TLS)
+//		-metrics-addr string
+//			Address to serve Prometheus /metrics on, separate from -addr
+//			(default: serve /metrics on -addr only)
+//		-auth-psk-env string
+//			Environment variable to retrieve a pre-shared key from; if
+//			set, requests must present Authorization: Bearer <psk>
+//			(mutually exclusive with -auth-hmac-env)
+//		-auth-hmac-env string
+//			Environment variable to retrieve an HMAC signing secret
+//			from; if set, requests must be signed (mutually exclusive
+//			with -auth-psk-env)
+//		-chunk-max-pairs int
+//			Commit a PUT body in chunks of at most this many KV pairs
+//			instead of one transaction for the whole request (0
+//			disables the pair limit)
+//		-chunk-max-bytes int
+//			Commit a PUT body in chunks of at most this many ciphertext
+//			bytes (0 disables the byte limit; one of -chunk-max-pairs or
+//			-chunk-max-bytes must be positive to enable chunked commit)
 //
 //	$ bin/kryptografpersister -db
 //	$ bin/kryptografpersister -db ~/test.db
@@ -53,6 +81,15 @@ var (
 	encryptionKey    string
 	encryptionKeyEnv string
 	dbFile           string
+	tlsCert          string
+	tlsKey           string
+	clientCA         string
+	http2            bool
+	metricsAddr      string
+	authPSKEnv       string
+	authHMACEnv      string
+	chunkMaxPairs    int
+	chunkMaxBytes    int64
 )
 
 func init() {
@@ -60,6 +97,15 @@ func init() {
 	flag.StringVar(&listenTo, "addr", DefaultAddress, "Address to bind the Persister http server to")
 	flag.StringVar(&encryptionKeyEnv, "encryption-key-env", DefaultEncryptionKeyEnv, "Environment variable to retrieve the encryption key used to load and store data in the AnyStoreDB")
 	flag.StringVar(&dbFile, "db", DefaultAnyStoreDBFile, "AnyStore DB file used as backend for the storage API")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate file to serve HTTPS with (requires -tls-key)")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key file matching -tls-cert")
+	flag.StringVar(&clientCA, "client-ca", "", "PEM file of CA certificates to require and verify client certificates against (enables mutual TLS)")
+	flag.BoolVar(&http2, "http2", false, "Configure the server for HTTP/2 (implies TLS)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus /metrics on, separate from -addr (default: serve /metrics on -addr only)")
+	flag.StringVar(&authPSKEnv, "auth-psk-env", "", "Environment variable to retrieve a pre-shared key from; if set, requests must present Authorization: Bearer <psk> (mutually exclusive with -auth-hmac-env)")
+	flag.StringVar(&authHMACEnv, "auth-hmac-env", "", "Environment variable to retrieve an HMAC signing secret from; if set, requests must be signed (mutually exclusive with -auth-psk-env)")
+	flag.IntVar(&chunkMaxPairs, "chunk-max-pairs", 0, "Commit a PUT body in chunks of at most this many KV pairs instead of one transaction for the whole request (0 disables the pair limit)")
+	flag.Int64Var(&chunkMaxBytes, "chunk-max-bytes", 0, "Commit a PUT body in chunks of at most this many ciphertext bytes (0 disables the byte limit; one of -chunk-max-pairs or -chunk-max-bytes must be positive to enable chunked commit)")
 }
 
 func main() {
@@ -68,17 +114,63 @@ func main() {
 	if encryptionKey = strings.TrimSpace(os.Getenv(encryptionKeyEnv)); encryptionKey == "" {
 		encryptionKey = DefaultEncryptionKey
 	}
+
+	var opts []server.Option
+	if tlsCert != "" || tlsKey != "" || clientCA != "" || http2 {
+		opts = append(opts, server.WithTransport(&server.TLSTransport{
+			CertFile:     tlsCert,
+			KeyFile:      tlsKey,
+			ClientCAFile: clientCA,
+			HTTP2:        http2,
+		}))
+	}
+
+	metrics := server.NewMetrics()
+	if metricsAddr != "" {
+		opts = append(opts, server.WithMetricsOnSeparateListener(metrics))
+	} else {
+		opts = append(opts, server.WithMetrics(metrics))
+	}
+
+	if authPSKEnv != "" {
+		psk := strings.TrimSpace(os.Getenv(authPSKEnv))
+		if psk == "" {
+			log.Fatalf("-auth-psk-env %q is set but empty", authPSKEnv)
+		}
+		opts = append(opts, server.WithPSKAuth(psk))
+	} else if authHMACEnv != "" {
+		secret := strings.TrimSpace(os.Getenv(authHMACEnv))
+		if secret == "" {
+			log.Fatalf("-auth-hmac-env %q is set but empty", authHMACEnv)
+		}
+		opts = append(opts, server.WithHMACAuth(secret))
+	}
+
+	if chunkMaxPairs > 0 || chunkMaxBytes > 0 {
+		opts = append(opts, server.WithChunkedCommit(chunkMaxPairs, chunkMaxBytes))
+	}
+
 	returnCh, terminator, _, err := server.Start(protocol, listenTo, dbFile, encryptionKey, log.Default(), &http.Server{
 		ReadTimeout:  5 * time.Minute,
 		WriteTimeout: 5 * time.Minute,
 		IdleTimeout:  5 * time.Minute,
-	})
+	}, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer close(terminator)
 	defer close(returnCh)
 
+	if metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics)
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, metricsMux); err != nil {
+				log.Printf("metrics server on %q: %v", metricsAddr, err)
+			}
+		}()
+	}
+
 	err = <-returnCh
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPSKAuthRejectsMissingOrWrongBearerToken(t *testing.T) {
+	kurl := startTestPersister(t, WithPSKAuth("s3cr3t"))
+
+	resp := putKV(t, kurl, map[string][]byte{"key1": []byte("hello")}, nil)
+	if got, expected := resp.StatusCode, http.StatusUnauthorized; got != expected {
+		t.Errorf("expected %d with no Authorization header, got %d", expected, got)
+	}
+
+	resp = putKV(t, kurl, map[string][]byte{"key1": []byte("hello")}, map[string]string{
+		AuthorizationHeader: BearerPrefix + "wrong",
+	})
+	if got, expected := resp.StatusCode, http.StatusUnauthorized; got != expected {
+		t.Errorf("expected %d with a wrong bearer token, got %d", expected, got)
+	}
+
+	resp = putKV(t, kurl, map[string][]byte{"key1": []byte("hello")}, map[string]string{
+		AuthorizationHeader: BearerPrefix + "s3cr3t",
+	})
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Errorf("expected %d with the correct bearer token, got %d", expected, got)
+	}
+}
+
+func TestHMACAuthRejectsMissingOrWrongSignature(t *testing.T) {
+	kurl := startTestPersister(t, WithHMACAuth("s3cr3t"))
+
+	resp := putKV(t, kurl, map[string][]byte{"key1": []byte("hello")}, nil)
+	if got, expected := resp.StatusCode, http.StatusUnauthorized; got != expected {
+		t.Errorf("expected %d with no signature headers, got %d", expected, got)
+	}
+
+	pairs := map[string][]byte{"key1": []byte("hello")}
+	body, err := json.Marshal(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Now().Unix()
+	sig, err := signHMACRequest("wrong-secret", http.MethodPut, "/", body, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = putKV(t, kurl, pairs, map[string]string{
+		HMACSignatureHeader: sig,
+		HMACTimestampHeader: strconv.FormatInt(ts, 10),
+	})
+	if got, expected := resp.StatusCode, http.StatusUnauthorized; got != expected {
+		t.Errorf("expected %d with a signature from the wrong secret, got %d", expected, got)
+	}
+
+	sig, err = signHMACRequest("s3cr3t", http.MethodPut, "/", body, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = putKV(t, kurl, pairs, map[string]string{
+		HMACSignatureHeader: sig,
+		HMACTimestampHeader: strconv.FormatInt(ts, 10),
+	})
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Errorf("expected %d with a correctly signed request, got %d", expected, got)
+	}
+}
+
+func TestHMACAuthRejectsStaleTimestamp(t *testing.T) {
+	kurl := startTestPersister(t, WithHMACAuth("s3cr3t"))
+
+	pairs := map[string][]byte{"key1": []byte("hello")}
+	body, err := json.Marshal(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Now().Add(-2 * HMACReplayWindow).Unix()
+	sig, err := signHMACRequest("s3cr3t", http.MethodPut, "/", body, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := putKV(t, kurl, pairs, map[string]string{
+		HMACSignatureHeader: sig,
+		HMACTimestampHeader: strconv.FormatInt(ts, 10),
+	})
+	if got, expected := resp.StatusCode, http.StatusUnauthorized; got != expected {
+		t.Errorf("expected %d for a timestamp outside the replay window, got %d", expected, got)
+	}
+}
+
+// signHMACRequest mirrors checkHMACAuth's signing string so tests can
+// act as a correctly-behaving client.
+func signHMACRequest(secret, method, path string, body []byte, ts int64) (string, error) {
+	bodySHA := sha256.Sum256(body)
+	signingString := fmt.Sprintf("%s|%s|%s|%d", method, path, hex.EncodeToString(bodySHA[:]), ts)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingString))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// UploadIDHeader and PersistedCountHeader implement a minimal
+// resumable-upload protocol on top of PUT. A client that sets
+// UploadIDHeader to the same value on every attempt of one logical
+// upload can retry a PUT that was dropped partway through (network
+// error, timeout) by resending the full stream unchanged: StoreJsonKV
+// skips the KV pairs it already persisted for that id and only
+// stores the rest. PersistedCountHeader on the response reports how
+// many pairs have been durably persisted for UploadIDHeader so far,
+// for the client to track progress or decide whether to retry.
+const (
+	UploadIDHeader       string = "X-Upload-Id"
+	PersistedCountHeader string = "X-Persisted-Count"
+)
+
+// uploadTrackerTTL bounds how long an upload id is remembered since
+// it was last touched. Without it, a client (or anyone able to reach
+// an unauthenticated persister) could grow uploadTracker's map
+// without bound simply by sending PUTs under distinct upload ids.
+// TTL-expired ids are treated the same as ids never seen before: the
+// next PUT under that id starts from a persisted count of 0, same as
+// if the server had restarted.
+const uploadTrackerTTL = 1 * time.Hour
+
+// uploadTrackerSweepInterval is the minimum time between sweeps of
+// expired upload ids, so persisted/advance don't each pay for a full
+// map scan on every call.
+const uploadTrackerSweepInterval = time.Minute
+
+// uploadTracker remembers, per upload id, how many KV pairs have
+// been durably committed so far, so a retried PUT of the same upload
+// id can resume instead of re-storing pairs the server already has.
+// It is process-local, in memory only: restarting the server forgets
+// in-flight uploads, same as a client that never set UploadIDHeader
+// in the first place. Entries older than uploadTrackerTTL are swept
+// so an unbounded stream of upload ids can't grow it forever.
+type uploadTracker struct {
+	mu        sync.Mutex
+	count     map[string]int
+	lastSeen  map[string]time.Time
+	lastSweep time.Time
+}
+
+func newUploadTracker() *uploadTracker {
+	return &uploadTracker{
+		count:    make(map[string]int),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// touch records that id was just accessed and, no more often than
+// every uploadTrackerSweepInterval, evicts every id whose last access
+// is older than uploadTrackerTTL. Callers must hold u.mu.
+func (u *uploadTracker) touch(id string) {
+	now := time.Now()
+	u.lastSeen[id] = now
+	if now.Sub(u.lastSweep) < uploadTrackerSweepInterval {
+		return
+	}
+	u.lastSweep = now
+	for seenID, seenAt := range u.lastSeen {
+		if now.Sub(seenAt) >= uploadTrackerTTL {
+			delete(u.lastSeen, seenID)
+			delete(u.count, seenID)
+		}
+	}
+}
+
+// persisted returns how many pairs have been committed for id so
+// far, or 0 if id is empty or unknown (including an id whose entry
+// has expired).
+func (u *uploadTracker) persisted(id string) int {
+	if u == nil || id == "" {
+		return 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.touch(id)
+	return u.count[id]
+}
+
+// advance records that n more pairs were committed for id and
+// returns the new cumulative count. It is a no-op if id is empty.
+func (u *uploadTracker) advance(id string, n int) int {
+	if u == nil || id == "" {
+		return 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.touch(id)
+	u.count[id] += n
+	return u.count[id]
+}
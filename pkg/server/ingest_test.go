@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChunkedCommitPersistsAllPairsAcrossMultipleChunks(t *testing.T) {
+	kurl := startTestPersister(t, WithChunkedCommit(1, 0))
+
+	resp := putKV(t, kurl, map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}, nil)
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+
+	got := loadAll(t, kurl)
+	if got, expected := len(got), 3; got != expected {
+		t.Fatalf("expected all 3 pairs to persist across separate chunks, got %d", got)
+	}
+}
+
+func TestResumableUploadSkipsAlreadyPersistedPairs(t *testing.T) {
+	kurl := startTestPersister(t)
+	uploadID := "upload-1"
+
+	resp := putKV(t, kurl, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, map[string]string{
+		UploadIDHeader: uploadID,
+	})
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected %d from first attempt, got %d", expected, got)
+	}
+	if got, expected := resp.Header.Get(PersistedCountHeader), "2"; got != expected {
+		t.Fatalf("expected %s %q after first attempt, got %q", PersistedCountHeader, expected, got)
+	}
+
+	// Simulate a client retrying the same logical upload after a
+	// dropped connection: resend the full stream plus one new pair
+	// under the same upload id.
+	resp = putKV(t, kurl, map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}, map[string]string{
+		UploadIDHeader: uploadID,
+	})
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected %d from retried attempt, got %d", expected, got)
+	}
+	if got, expected := resp.Header.Get(PersistedCountHeader), "3"; got != expected {
+		t.Errorf("expected %s %q after retried attempt (only the new pair counted), got %q", PersistedCountHeader, expected, got)
+	}
+
+	got := loadAll(t, kurl)
+	if got, expected := len(got), 3; got != expected {
+		t.Errorf("expected exactly 3 distinct keys stored after the retry, got %d", got)
+	}
+}
+
+func TestResumableUploadTracksCountAcrossChunkBoundaries(t *testing.T) {
+	kurl := startTestPersister(t, WithChunkedCommit(1, 0))
+	uploadID := "upload-2"
+
+	// Two pairs with a one-pair chunk limit commit as two chunks.
+	resp := putKV(t, kurl, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, map[string]string{
+		UploadIDHeader: uploadID,
+	})
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	if got, expected := resp.Header.Get(PersistedCountHeader), strconv.Itoa(2); got != expected {
+		t.Errorf("expected %s %q, got %q", PersistedCountHeader, expected, got)
+	}
+}
+
+func TestUploadTrackerEvictsExpiredEntries(t *testing.T) {
+	u := newUploadTracker()
+	u.advance("stale", 5)
+
+	// Backdate the entry past its TTL and force the next access to
+	// sweep immediately, rather than waiting out uploadTrackerTTL.
+	u.lastSeen["stale"] = time.Now().Add(-2 * uploadTrackerTTL)
+	u.lastSweep = time.Now().Add(-2 * uploadTrackerSweepInterval)
+
+	if got, expected := u.persisted("fresh"), 0; got != expected {
+		t.Fatalf("expected %d for an unseen id, got %d", expected, got)
+	}
+	if _, ok := u.count["stale"]; ok {
+		t.Error("expected the expired upload id to be evicted from count")
+	}
+	if _, ok := u.lastSeen["stale"]; ok {
+		t.Error("expected the expired upload id to be evicted from lastSeen")
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Logger is the minimal logging surface Start needs. *log.Logger
+// satisfies it already (Print, Printf and Println all exist on it),
+// so passing log.Default() or any *log.Logger keeps working
+// unchanged; operators who want structured logs can instead pass an
+// adapter around their own *slog.Logger, or use WithStructuredLogger
+// (options.go) for JSON request logs alongside it.
+type Logger interface {
+	Print(v ...any)
+	Printf(format string, v ...any)
+	Println(v ...any)
+}
+
+func logErr(l Logger, r *http.Request, err error) string {
+	str := fmt.Sprint(r.Method, " ", r.RequestURI, " from ", r.RemoteAddr, ": ", err.Error())
+	l.Print(str)
+	return str
+}
+
+func logMsg(l Logger, r *http.Request, msg string) string {
+	str := fmt.Sprint(r.Method, " ", r.RequestURI, " from ", r.RemoteAddr, ": ", msg)
+	l.Print(str)
+	return str
+}
+
+// LoggingMiddleware is a logging http.Handler.
+func LoggingMiddleware(l Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Println(r.Method, r.RequestURI, "from", r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusCapturingWriter wraps an http.ResponseWriter to record the
+// status code and byte count written, for structured request logs.
+type statusCapturingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusCapturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusCapturingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush forwards to the underlying ResponseWriter's http.Flusher, if
+// it has one, so wrapping a handler in StructuredLoggingMiddleware
+// doesn't break handlers (like handleWAL's follow=1 streaming) that
+// rely on flushing to deliver data as it's written rather than once
+// the response completes.
+func (w *statusCapturingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sequence hands out small, process-local, monotonically increasing
+// ids; they need not be globally unique, only useful for correlating
+// the lines of one request.
+type sequence struct{ n uint64 }
+
+func newSequence() *sequence { return &sequence{} }
+
+func (s *sequence) next() uint64 { return atomic.AddUint64(&s.n, 1) }
+
+// requestIDSeq hands out request ids for StructuredLoggingMiddleware.
+var requestIDSeq = newSequence()
+
+// withMiddleware wraps h with request-latency metrics (if o.metrics
+// is set) and then with either StructuredLoggingMiddleware (if
+// o.structuredLogger is set) or the plain-text LoggingMiddleware.
+func withMiddleware(o *options, l Logger, h http.Handler) http.Handler {
+	if o.metrics != nil {
+		h = metricsMiddleware(o.metrics, h)
+	}
+	if o.structuredLogger != nil {
+		return StructuredLoggingMiddleware(o.structuredLogger, h)
+	}
+	return LoggingMiddleware(l, h)
+}
+
+// StructuredLoggingMiddleware logs one JSON line per request via sl,
+// including a request id, remote address, method, path, status,
+// byte count and duration. Use it in place of LoggingMiddleware (see
+// WithStructuredLogger) when operators need machine-parseable logs.
+func StructuredLoggingMiddleware(sl *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqID := requestIDSeq.next()
+		sw := &statusCapturingWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		sl.Info("request",
+			"request_id", reqID,
+			"remote_addr", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"duration", time.Since(start),
+		)
+	})
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsNamespace prefixes every exported metric name.
+const metricsNamespace = "kryptografpersister"
+
+// Metrics collects counters and histograms for a running persister
+// and exposes them in Prometheus text exposition format from
+// ServeHTTP, so it can be mounted on the main mux or served on its
+// own listener via the -metrics-addr flag in main, keeping the data
+// API and the metrics endpoint separately firewallable.
+type Metrics struct {
+	anyStoreLen func() (int, error)
+
+	kvPairsStored          uint64
+	transactionsRolledBack uint64
+	decodeErrors           uint64
+	bytesIngested          uint64
+
+	mu              sync.Mutex
+	loadAllDuration histogram
+	requestLatency  map[string]*histogram // keyed by HTTP method
+}
+
+// NewMetrics returns an empty Metrics. Pass it to WithMetrics to
+// attach it to a server.Start call.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requestLatency: make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) setAnyStoreLenFunc(f func() (int, error)) {
+	m.anyStoreLen = f
+}
+
+func (m *Metrics) addKVPairsStored(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.kvPairsStored, uint64(n))
+}
+
+func (m *Metrics) addTransactionRolledBack() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.transactionsRolledBack, 1)
+}
+
+func (m *Metrics) addDecodeError() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.decodeErrors, 1)
+}
+
+func (m *Metrics) addBytesIngested(n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	atomic.AddUint64(&m.bytesIngested, uint64(n))
+}
+
+func (m *Metrics) observeLoadAllDuration(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadAllDuration.observe(d.Seconds())
+}
+
+func (m *Metrics) observeRequestLatency(method string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.requestLatency[method]
+	if !ok {
+		h = &histogram{}
+		m.requestLatency[method] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// ServeHTTP renders every collected metric in Prometheus text
+// exposition format, satisfying http.Handler so Metrics can be
+// mounted directly on a mux (e.g. at /metrics).
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(ContentTypeHeader, "text/plain; version=0.0.4; charset=utf-8")
+
+	writeCounter(w, "kv_pairs_stored_total", "Number of KV pairs persisted by StoreJsonKV.", atomic.LoadUint64(&m.kvPairsStored))
+	writeCounter(w, "transactions_rolled_back_total", "Number of StoreJsonKV transactions rolled back due to an error.", atomic.LoadUint64(&m.transactionsRolledBack))
+	writeCounter(w, "decode_errors_total", "Number of StoreJsonKV JSON decode errors.", atomic.LoadUint64(&m.decodeErrors))
+	writeCounter(w, "bytes_ingested_total", "Total ciphertext bytes ingested by StoreJsonKV.", atomic.LoadUint64(&m.bytesIngested))
+
+	if m.anyStoreLen != nil {
+		if n, err := m.anyStoreLen(); err == nil {
+			fmt.Fprintf(w, "# HELP %s_anystore_keys Current number of keys in the AnyStore.\n", metricsNamespace)
+			fmt.Fprintf(w, "# TYPE %s_anystore_keys gauge\n", metricsNamespace)
+			fmt.Fprintf(w, "%s_anystore_keys %d\n", metricsNamespace, n)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s_load_all_duration_seconds Duration of GET / (LoadAll) responses.\n", metricsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_load_all_duration_seconds histogram\n", metricsNamespace)
+	m.loadAllDuration.writeTo(w, metricsNamespace+"_load_all_duration_seconds", "")
+
+	fmt.Fprintf(w, "# HELP %s_request_duration_seconds Per-method HTTP request latency.\n", metricsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_request_duration_seconds histogram\n", metricsNamespace)
+	methods := make([]string, 0, len(m.requestLatency))
+	for method := range m.requestLatency {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		m.requestLatency[method].writeTo(w, metricsNamespace+"_request_duration_seconds", fmt.Sprintf("method=%q", method))
+	}
+}
+
+// metricsMiddleware records how long next takes to serve each
+// request, labelled by HTTP method, in m's request latency
+// histogram.
+func metricsMiddleware(m *Metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		m.observeRequestLatency(r.Method, time.Since(start))
+	})
+}
+
+func writeCounter(w io.Writer, name, help string, v uint64) {
+	fmt.Fprintf(w, "# HELP %s_%s %s\n", metricsNamespace, name, help)
+	fmt.Fprintf(w, "# TYPE %s_%s counter\n", metricsNamespace, name)
+	fmt.Fprintf(w, "%s_%s %d\n", metricsNamespace, name, v)
+}
+
+// histogram is a minimal cumulative-bucket histogram, enough to
+// expose Prometheus text format without depending on an external
+// client library. Not safe for concurrent use; callers serialize
+// access (Metrics does so with its mu).
+type histogram struct {
+	buckets []float64 // upper bounds, ascending, not including +Inf
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+var defaultHistogramBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+func (h *histogram) observe(seconds float64) {
+	if h.buckets == nil {
+		h.buckets = defaultHistogramBuckets
+		h.counts = make([]uint64, len(defaultHistogramBuckets))
+	}
+	for i, ub := range h.buckets {
+		if seconds <= ub {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// writeTo renders h as bucket/sum/count lines for metric name,
+// optionally scoped by a single "label=\"value\"" pair.
+func (h *histogram) writeTo(w io.Writer, name, label string) {
+	if h.buckets == nil {
+		h.buckets = defaultHistogramBuckets
+		h.counts = make([]uint64, len(defaultHistogramBuckets))
+	}
+	labelPrefix := ""
+	if label != "" {
+		labelPrefix = label + ","
+	}
+	for i, ub := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labelPrefix, ub, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labelPrefix, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, label, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, label, h.count)
+}
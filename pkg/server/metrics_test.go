@@ -0,0 +1,65 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpointMountedWithWithMetrics(t *testing.T) {
+	kurl := startTestPersister(t, WithMetrics(NewMetrics()))
+
+	if resp := putKV(t, kurl, map[string][]byte{"key1": []byte("hello")}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from PUT, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(kurl + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected status %d, got %d", expected, got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "kryptografpersister_kv_pairs_stored_total 1") {
+		t.Errorf("expected kv_pairs_stored_total to report 1, got body:\n%s", body)
+	}
+}
+
+func TestMetricsEndpointNotMountedOnSeparateListener(t *testing.T) {
+	kurl := startTestPersister(t, WithMetricsOnSeparateListener(NewMetrics()))
+
+	resp, err := http.Get(kurl + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With no dedicated "/metrics" route mounted, the request falls
+	// through to the catch-all "/" handler (a GET, i.e. LoadAll), so it
+	// must not come back as Prometheus exposition format.
+	if strings.Contains(string(body), "# HELP") {
+		t.Errorf("expected /metrics not to serve metrics when WithMetricsOnSeparateListener is used, got body:\n%s", body)
+	}
+}
+
+func TestStatusCapturingWriterFlushForwardsToUnderlyingFlusher(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &statusCapturingWriter{ResponseWriter: rec}
+
+	var flusher http.Flusher = w
+	flusher.Flush()
+
+	if !rec.Flushed {
+		t.Error("expected Flush to forward to the underlying http.Flusher")
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// options.go collects the functional options accepted by Start. The
+// options struct itself is unexported: callers configure the server
+// through the With* constructors returned by this file (and by
+// sibling files that add more options as the server grows
+// capabilities), never by touching fields directly.
+
+// Option configures optional behaviour of Start. Options are applied
+// in the order they are given, so a later option can override an
+// earlier one.
+type Option func(*options) error
+
+type options struct {
+	wal *WAL
+
+	standbyPrimaryURL string
+	standbyPSK        string
+	standbyWALDir     string // directory for the standby's replication state file, see WithStandby
+
+	transport Transport
+
+	metrics          *Metrics
+	mountMetrics     bool
+	structuredLogger *slog.Logger
+
+	authPSK        string
+	authHMACSecret string
+
+	chunkMaxPairs int
+	chunkMaxBytes int64
+	uploads       *uploadTracker
+}
+
+func newOptions() *options {
+	return &options{
+		transport: PlainTransport{},
+		uploads:   newUploadTracker(),
+	}
+}
+
+// WithMetrics attaches m to the server: every StoreJsonKV
+// transaction, LoadAll response and HTTP request updates its
+// counters and histograms, m.setAnyStoreLenFunc is wired to the
+// opened AnyStore's Len, and m is mounted as an http.Handler at
+// /metrics on the main listener. Construct m with NewMetrics. If the
+// caller instead serves m on its own listener (see the -metrics-addr
+// flag in main), use WithMetricsOnSeparateListener so /metrics isn't
+// also exposed on the main, possibly PSK/HMAC-protected, data API.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) error {
+		if m == nil {
+			return fmt.Errorf("metrics must not be nil")
+		}
+		o.metrics = m
+		o.mountMetrics = true
+		return nil
+	}
+}
+
+// WithMetricsOnSeparateListener attaches m exactly like WithMetrics
+// (counters, histograms, anyStore.Len all get wired up the same
+// way), but leaves /metrics off the main listener's mux. Use this
+// when m will instead be served on its own listener, so a
+// firewalled-off -metrics-addr doesn't end up duplicated onto the
+// main address anyway.
+func WithMetricsOnSeparateListener(m *Metrics) Option {
+	return func(o *options) error {
+		if m == nil {
+			return fmt.Errorf("metrics must not be nil")
+		}
+		o.metrics = m
+		o.mountMetrics = false
+		return nil
+	}
+}
+
+// WithStructuredLogger makes Start log each request as a single JSON
+// line via sl (request id, remote address, method, path, status,
+// byte count and duration) instead of the plain-text LoggingMiddleware.
+func WithStructuredLogger(sl *slog.Logger) Option {
+	return func(o *options) error {
+		if sl == nil {
+			return fmt.Errorf("structured logger must not be nil")
+		}
+		o.structuredLogger = sl
+		return nil
+	}
+}
+
+// WithTransport overrides the Transport Start uses to listen for and
+// serve connections. The default is PlainTransport (plaintext TCP).
+// Use &TLSTransport{...} for HTTPS, optionally with mutual TLS and/or
+// HTTP/2.
+func WithTransport(t Transport) Option {
+	return func(o *options) error {
+		if t == nil {
+			return fmt.Errorf("transport must not be nil")
+		}
+		o.transport = t
+		return nil
+	}
+}
+
+// WithChunkedCommit makes StoreJsonKV commit a PUT body in chunks of
+// at most maxPairs KV pairs or maxBytes of ciphertext, whichever
+// limit is reached first, instead of one Run transaction for the
+// whole request. Each chunk is committed (and, if WithWAL is
+// configured, appended to the WAL) independently, so a failure
+// partway through a large upload only rolls back the chunk that
+// failed rather than the entire request; combined with
+// UploadIDHeader, a client can then retry and resume from the last
+// committed chunk instead of starting over. maxPairs <= 0 means no
+// pair limit; maxBytes <= 0 means no byte limit; at least one must
+// be positive. Without this option, StoreJsonKV commits the whole
+// stream atomically as before.
+func WithChunkedCommit(maxPairs int, maxBytes int64) Option {
+	return func(o *options) error {
+		if maxPairs <= 0 && maxBytes <= 0 {
+			return fmt.Errorf("at least one of maxPairs or maxBytes must be positive")
+		}
+		o.chunkMaxPairs = maxPairs
+		o.chunkMaxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithWAL enables write-ahead logging of every StoreJsonKV
+// transaction to dir before it is committed to the AnyStore. The
+// returned *WAL is also exposed on the /wal endpoint so standbys can
+// replicate from it.
+func WithWAL(dir string) Option {
+	return func(o *options) error {
+		w, err := OpenWAL(dir)
+		if err != nil {
+			return err
+		}
+		o.wal = w
+		return nil
+	}
+}
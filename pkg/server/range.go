@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sa6mwa/anystore"
+)
+
+// The corresponding DeleteRange/LoadRange convenience methods on
+// kryptograf.PersistenceClient live in the sa6mwa/kryptograf module,
+// not in this repository, and are not added here.
+
+// RangeQuery is the JSON body accepted by POST /, selecting entries
+// whose AnyStore key (a RandomStamp, and therefore
+// lexicographically time-sortable) falls within [From, To]. An empty
+// From or To leaves that side of the range unbounded. Limit, if
+// greater than zero, caps the number of matching entries streamed
+// back.
+type RangeQuery struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Limit int    `json:"limit"`
+}
+
+// inRange reports whether storeKey falls within [from, to], treating
+// an empty from or to as unbounded. It relies on RandomStamp keys
+// being lexicographically time-sortable, so plain string comparison
+// against the stamp is enough to bound a time range.
+func inRange(storeKey, from, to string) bool {
+	if from != "" && storeKey < from {
+		return false
+	}
+	if to != "" && storeKey > to {
+		return false
+	}
+	return true
+}
+
+// handleDeleteRange implements DELETE /?from=<stamp>&to=<stamp>&key=<exact>:
+// it atomically removes every entry whose AnyStore key falls in
+// [from, to] and whose Data.Key equals key (when key is given),
+// rolling back (re-storing) any already-deleted entries if a later
+// Load or Delete, or the WAL append recording the deletions, fails in
+// the same transaction.
+func handleDeleteRange(anyStore anystore.AnyStore, o *options, l Logger, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, exactKey := q.Get("from"), q.Get("to"), q.Get("key")
+
+	deleted := 0
+	if err := anyStore.Run(func(s anystore.AnyStore) error {
+		keys, err := s.Keys()
+		if err != nil {
+			return err
+		}
+		rolledBack := make(map[string]Data)
+		rollback := func() {
+			for k, d := range rolledBack {
+				s.Store(k, d)
+			}
+		}
+		tombstones := make([]Data, 0)
+		for _, storeKey := range keys {
+			if !inRange(storeKey, from, to) {
+				continue
+			}
+			v, err := s.Load(storeKey)
+			if err != nil {
+				rollback()
+				return err
+			}
+			data, ok := v.(Data)
+			if !ok {
+				rollback()
+				return fmt.Errorf("expected Data type, but got %T", v)
+			}
+			if exactKey != "" && data.Key != exactKey {
+				continue
+			}
+			if err := s.Delete(storeKey); err != nil {
+				for k, d := range rolledBack {
+					s.Store(k, d)
+				}
+				return err
+			}
+			rolledBack[storeKey] = data
+			tombstones = append(tombstones, Data{Key: data.Key, StoreKey: storeKey, Deleted: true})
+			deleted++
+		}
+		if o.wal != nil && len(tombstones) > 0 {
+			if _, err := o.wal.Append(tombstones); err != nil {
+				for k, d := range rolledBack {
+					s.Store(k, d)
+				}
+				return fmt.Errorf("appending to WAL: %w", err)
+			}
+		}
+		return nil
+	}); err != nil {
+		logErr(l, r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("Error: unable to delete matching key-value pairs, all deletions in this transaction rolled back: %v", err)}))
+		return
+	}
+
+	logMsg(l, r, fmt.Sprintf("deleted %d key-value pair(s)", deleted))
+	w.WriteHeader(http.StatusOK)
+	w.Write(ToJson(&Msg{Msg: fmt.Sprintf("Deleted %d key-value pair(s).", deleted)}))
+}
+
+// handleRangeQuery implements POST /: it decodes a RangeQuery from
+// the request body and streams back matching KV pairs in the same
+// newline-delimited map[string][]byte format GET / uses, in AnyStore
+// key order, stopping early once Limit matches have been written.
+func handleRangeQuery(anyStore anystore.AnyStore, l Logger, w http.ResponseWriter, r *http.Request) {
+	var q RangeQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		logErr(l, r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("Error: unable to decode range query: %v", err)}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	matched := 0
+	if err := anyStore.Run(func(s anystore.AnyStore) error {
+		keys, err := s.Keys()
+		if err != nil {
+			return err
+		}
+		for _, storeKey := range keys {
+			if q.Limit > 0 && matched >= q.Limit {
+				break
+			}
+			if !inRange(storeKey, q.From, q.To) {
+				continue
+			}
+			v, err := s.Load(storeKey)
+			if err != nil {
+				return err
+			}
+			data, ok := v.(Data)
+			if !ok {
+				return fmt.Errorf("expected Data type, but got %T", v)
+			}
+			kv := map[string][]byte{data.Key: data.Ciphertext}
+			j, err := json.Marshal(&kv)
+			if err != nil {
+				return err
+			}
+			j = append(j, '\n')
+			if _, err := w.Write(j); err != nil {
+				return err
+			}
+			matched++
+		}
+		return nil
+	}); err != nil {
+		logErr(l, r, err)
+		msg := map[string][]byte{"SERVER_ERROR": []byte(err.Error())}
+		w.Write(ToJson(msg))
+		return
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDeleteRangeByExactKeyWritesTombstoneToWAL(t *testing.T) {
+	walDir := t.TempDir()
+	kurl := startTestPersister(t, WithWAL(walDir))
+
+	if resp := putKV(t, kurl, map[string][]byte{"key1": []byte("hello"), "key2": []byte("world")}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from PUT, got %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, kurl+"?key=key1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected status %d, got %d", expected, got)
+	}
+
+	got := loadAll(t, kurl)
+	if _, ok := got["key1"]; ok {
+		t.Error("expected key1 to be deleted")
+	}
+	if string(got["key2"]) != "world" {
+		t.Errorf("expected key2 to survive the delete, got %q", got["key2"])
+	}
+
+	recs, err := OpenWAL(walDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recs.Close()
+	since, err := recs.Since(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sawTombstone bool
+	for _, rec := range since {
+		for _, d := range rec.Entries {
+			if d.Key == "key1" && d.Deleted {
+				sawTombstone = true
+			}
+		}
+	}
+	if !sawTombstone {
+		t.Error("expected a Deleted tombstone for key1 to be appended to the WAL")
+	}
+}
+
+func TestRangeQueryLimitsMatches(t *testing.T) {
+	kurl := startTestPersister(t)
+
+	if resp := putKV(t, kurl, map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from PUT, got %d", resp.StatusCode)
+	}
+
+	body, err := json.Marshal(&RangeQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(kurl, ApplicationJSON, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, expected := resp.StatusCode, http.StatusOK; got != expected {
+		t.Fatalf("expected status %d, got %d", expected, got)
+	}
+
+	matched := 0
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var kv map[string][]byte
+		if err := dec.Decode(&kv); err != nil {
+			t.Fatal(err)
+		}
+		matched++
+	}
+	if got, expected := matched, 2; got != expected {
+		t.Errorf("expected %d matches with Limit 2, got %d", expected, got)
+	}
+}
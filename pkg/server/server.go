@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -31,32 +33,23 @@ const (
 type Data struct {
 	Key        string `json:"key"`
 	Ciphertext []byte `json:"ciphertext"`
+	// StoreKey is the RandomStamp key Data was stored under in the
+	// AnyStore. It is populated by StoreJsonKV inside the Run
+	// transaction, just before the Data is stored, and is only meaningful on the WAL (a standby needs it to
+	// apply the record under the same key the primary used); it is not
+	// part of the client-facing wire format.
+	StoreKey string `json:"storeKey,omitempty"`
+	// Deleted marks a tombstone entry written to the WAL by
+	// handleDeleteRange: on apply, a standby deletes StoreKey instead
+	// of storing this Data. Like StoreKey, it is only meaningful on the
+	// WAL and is never part of the client-facing wire format.
+	Deleted bool `json:"deleted,omitempty"`
 }
 
 func init() {
 	gob.Register(Data{})
 }
 
-func logErr(l *log.Logger, r *http.Request, err error) string {
-	str := fmt.Sprint(r.Method, " ", r.RequestURI, " from ", r.RemoteAddr, ": ", err.Error())
-	l.Print(str)
-	return str
-}
-
-func logMsg(l *log.Logger, r *http.Request, msg string) string {
-	str := fmt.Sprint(r.Method, " ", r.RequestURI, " from ", r.RemoteAddr, ": ", msg)
-	l.Print(str)
-	return str
-}
-
-// LoggingMiddleware is a logging http.Handler.
-func LoggingMiddleware(l *log.Logger, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		l.Println(r.Method, r.RequestURI, "from", r.RemoteAddr)
-		next.ServeHTTP(w, r)
-	})
-}
-
 // Start starts the kryptografpersister HTTP server, serving the API
 // on proto at addr using AnyStoreDB persistence file dbFile with
 // encryptionKey. Logging is done through l (if nil, log.Default()
@@ -65,7 +58,9 @@ func LoggingMiddleware(l *log.Logger, next http.Handler) http.Handler {
 // channel that will return nil or error when server is closed, a
 // terminator channel that, when closed, will terminate the http
 // server. The actual listen address from net.Listen is returned as a
-// string pointer. Usage example:
+// string pointer. opts can be used to enable optional behaviour such
+// as WithWAL (primary mode) or WithStandby (standby mode). Usage
+// example:
 //
 //	returnCh, terminator, addr, err := server.Start("tcp", ":0", dbFile, "lhOAmgGdrFnfnsysiFMTwTZ227LxlFemjuRL72yPkRo", log.Default(), nil)
 //	if err != nil {
@@ -82,13 +77,23 @@ func LoggingMiddleware(l *log.Logger, next http.Handler) http.Handler {
 //	if err != nil {
 //		log.Fatal(err)
 //	}
-func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.Server) (chan error, chan struct{}, *string, error) {
+func Start(proto, addr, dbFile, encryptionKey string, l Logger, srv *http.Server, opts ...Option) (chan error, chan struct{}, *string, error) {
+	o := newOptions()
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, nil, nil, fmt.Errorf("applying server option: %w", err)
+		}
+	}
+
 	anyStore, err := anystore.NewAnyStore(&anystore.Options{
 		EnablePersistence: true,
 		PersistenceFile:   dbFile,
 		EncryptionKey:     encryptionKey,
 	})
 	if err != nil {
+		if o.wal != nil {
+			o.wal.Close()
+		}
 		return nil, nil, nil, err
 	}
 
@@ -101,6 +106,9 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 	length, err := anyStore.Len()
 	if err != nil {
 		anyStore.Close()
+		if o.wal != nil {
+			o.wal.Close()
+		}
 		return nil, nil, nil, err
 	}
 	plural := "s"
@@ -109,6 +117,10 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 	}
 	l.Printf("Persistence file %q contains %d key"+plural, dbFile, length)
 
+	if o.metrics != nil {
+		o.metrics.setAnyStoreLenFunc(anyStore.Len)
+	}
+
 	mux := http.NewServeMux()
 
 	if srv == nil {
@@ -123,16 +135,27 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 		srv.Handler = mux
 	}
 
-	mux.Handle("/", LoggingMiddleware(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/", withMiddleware(o, l, authMiddleware(o, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(AcceptHeader, ApplicationJSON)
 		w.Header().Set(ContentTypeHeader, ApplicationJSON)
 
 		switch r.Method {
 		case http.MethodPut:
-			if d, err := StoreJsonKV(anyStore, r.Body); err != nil {
+			if o.standbyPrimaryURL != "" {
+				logErr(l, r, errors.New("standby is read-only"))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write(ToJson(&Msg{Msg: fmt.Sprintf("This persister is a standby of %q, write to the primary instead.", o.standbyPrimaryURL)}))
+				return
+			}
+			uploadID := r.Header.Get(UploadIDHeader)
+			d, persistedCount, err := StoreJsonKV(anyStore, r.Body, o, uploadID)
+			if uploadID != "" {
+				w.Header().Set(PersistedCountHeader, strconv.Itoa(persistedCount))
+			}
+			if err != nil {
 				logErr(l, r, err)
 				w.WriteHeader(http.StatusBadRequest)
-				w.Write(ToJson(&Msg{Msg: fmt.Sprintf("Error: unable to store key-value pairs, all pairs in this transaction rolled back: %v", err)}))
+				w.Write(ToJson(&Msg{Msg: fmt.Sprintf("Error: unable to store key-value pairs, the failing chunk was rolled back (%d pairs already persisted): %v", persistedCount, err)}))
 				return
 			} else {
 				length := len(d)
@@ -148,6 +171,8 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 			// Despite 200 OK, it Will return a {"SERVER_ERROR":"error
 			// message"} json in case something fails in the AnyStore Run
 			// transaction. The client API will pick this up.
+			loadAllStart := time.Now()
+			defer func() { o.metrics.observeLoadAllDuration(time.Since(loadAllStart)) }()
 			w.WriteHeader(http.StatusOK)
 			if err := anyStore.Run(func(s anystore.AnyStore) error {
 				keys, err := s.Keys()
@@ -188,10 +213,17 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 				return
 			}
 			return
-		case http.MethodPost, http.MethodDelete:
-			logErr(l, r, errors.New("method not implemented yet"))
-			w.WriteHeader(http.StatusNotImplemented)
-			w.Write(ToJson(&Msg{Msg: "Method not implemented yet."}))
+		case http.MethodDelete:
+			if o.standbyPrimaryURL != "" {
+				logErr(l, r, errors.New("standby is read-only"))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write(ToJson(&Msg{Msg: fmt.Sprintf("This persister is a standby of %q, write to the primary instead.", o.standbyPrimaryURL)}))
+				return
+			}
+			handleDeleteRange(anyStore, o, l, w, r)
+			return
+		case http.MethodPost:
+			handleRangeQuery(anyStore, l, w, r)
 			return
 		default:
 			logErr(l, r, errors.New("bad request"))
@@ -201,15 +233,38 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 		}
 		w.Write(ToJson(&Msg{Msg: "OK"}))
 		return
-	})))
+	}))))
+
+	if o.wal != nil {
+		mux.Handle("/wal", withMiddleware(o, l, authMiddleware(o, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleWAL(o.wal, w, r)
+		}))))
+		mux.Handle("/wal/ack", withMiddleware(o, l, authMiddleware(o, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleWALAck(o.wal, w, r)
+		}))))
+	}
+
+	if o.metrics != nil && o.mountMetrics {
+		mux.Handle("/metrics", o.metrics)
+	}
 
 	// Default proto is tcp4
 	if proto == "" {
 		proto = "tcp4"
 	}
-	ln, err := net.Listen(proto, addr)
+	if err := o.transport.ConfigureServer(srv); err != nil {
+		anyStore.Close()
+		if o.wal != nil {
+			o.wal.Close()
+		}
+		return nil, nil, nil, fmt.Errorf("configuring transport: %w", err)
+	}
+	ln, err := o.transport.Listen(proto, addr)
 	if err != nil {
 		anyStore.Close()
+		if o.wal != nil {
+			o.wal.Close()
+		}
 		return nil, nil, nil, err
 	}
 	lnAddr := ln.Addr().String()
@@ -217,6 +272,12 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 	returnCh := make(chan error)
 	terminator := make(chan struct{})
 	listenAndServeCh := make(chan error)
+
+	standbyCtx, cancelStandby := context.WithCancel(context.Background())
+	if o.standbyPrimaryURL != "" {
+		go runStandby(standbyCtx, o, anyStore, l)
+	}
+
 	go func() {
 		var e error
 		signalChannel := make(chan os.Signal, 1)
@@ -230,10 +291,14 @@ func Start(proto, addr, dbFile, encryptionKey string, l *log.Logger, srv *http.S
 		}
 		signal.Stop(signalChannel)
 		close(signalChannel)
+		cancelStandby()
 		if err := srv.Shutdown(context.Background()); err != nil {
 			l.Print("HTTP server Shutdown: ", err.Error())
 		}
 		anyStore.Close()
+		if o.wal != nil {
+			o.wal.Close()
+		}
 		if e == nil {
 			returnCh <- err
 		} else {
@@ -300,65 +365,155 @@ func RandomStamp(tm ...time.Time) string {
 	return t.Format(format) + fmt.Sprintf("_%.19d", crand.Int63())
 }
 
-// StoreJsonKV stores a {"key":"base64_ciphertext"} json object from
-// stream into the a AnyStore atomically with a unique random key (as
-// AnyStore key) and ensures key does not exist before storing, all
-// done in a Run transaction. The incoming KV pair is stored as a
-// server.Data object. In case there is any error in the stream, all
-// already stored key-value pairs are deleted (rolled back) and the
-// function returns an error (i.e operation is atomic). If StoreJsonKV
-// does not return an error, all KV pairs in the stream were
-// successfully persisted to the AnyStore. Returns a Data slice with
-// all persisted objects or error.
-func StoreJsonKV(a anystore.AnyStore, stream io.Reader) ([]Data, error) {
-	transaction := make([]Data, 0)
+// StoreJsonKV stores a stream of {"key":"base64_ciphertext"} json
+// objects from stream into a under unique RandomStamp keys. Decoded
+// KV pairs are buffered in the current chunk and Stored when that
+// chunk is committed; without chunked commit the chunk is the whole
+// stream, so every pair of the request is held in memory before the
+// single Run transaction opens. The returned slice also holds every
+// Data persisted in this call.
+//
+// By default the whole stream is committed as a single AnyStore Run
+// transaction: on a decode error nothing is stored, and on a store
+// error every key written so far is rolled back, and StoreJsonKV
+// returns the error (the operation is atomic). If o.chunkMaxPairs or o.chunkMaxBytes is set
+// (see WithChunkedCommit), the stream is instead committed in chunks
+// of at most that many pairs or ciphertext bytes, whichever limit is
+// reached first: each chunk is its own Run transaction, so a failure
+// only rolls back the chunk that failed and bounds the cost of a
+// rollback to one chunk instead of the whole upload.
+//
+// If uploadID is non-empty, o's upload tracker makes the PUT
+// resumable: pairs already recorded as persisted for uploadID are
+// decoded and skipped rather than re-stored, and every chunk
+// committed in this call advances the tracker. A client that resends
+// the full stream under the same X-Upload-Id after a dropped
+// connection therefore only pays for re-decoding, not re-storing,
+// and picks up at the last committed chunk.
+//
+// Returns every newly persisted Data (skipped pairs are not
+// included) and the cumulative number of pairs persisted for
+// uploadID (or, if uploadID is empty, the number persisted in this
+// call) so the caller can report it in the PersistedCountHeader
+// response header. If o.wal is non-nil, every chunk is appended to
+// it from within the same AnyStore Run transaction, before the
+// transaction commits, so a failed WAL append rolls back the
+// chunk's stores the same as a failed AnyStore write would. If
+// o.metrics is non-nil, it is updated with decode errors,
+// rolled-back transactions, and the KV pairs and bytes stored.
+func StoreJsonKV(a anystore.AnyStore, stream io.Reader, o *options, uploadID string) ([]Data, int, error) {
+	wal := o.wal
+	metrics := o.metrics
+	toSkip := o.uploads.persisted(uploadID)
+	skipped := 0
+
+	maxPairs := o.chunkMaxPairs
+	maxBytes := o.chunkMaxBytes
+	chunked := maxPairs > 0 || maxBytes > 0
+
+	persisted := make([]Data, 0)
+	chunk := make([]Data, 0)
+	var chunkBytes int64
+
+	persistedCount := func() int {
+		if uploadID != "" {
+			return o.uploads.persisted(uploadID)
+		}
+		return len(persisted)
+	}
+
+	commitChunk := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := a.Run(func(s anystore.AnyStore) error {
+			keysToRollBack := make([]string, 0, len(chunk))
+			for i := range chunk {
+				key := RandomStamp()
+				for {
+					if s.HasKey(key) {
+						key = RandomStamp()
+					} else {
+						break
+					}
+				}
+				chunk[i].StoreKey = key
+				if err := s.Store(key, chunk[i]); err != nil {
+					for _, k := range keysToRollBack {
+						s.Delete(k)
+					}
+					return err
+				}
+				keysToRollBack = append(keysToRollBack, key)
+			}
+			if wal != nil {
+				if _, err := wal.Append(chunk); err != nil {
+					for _, k := range keysToRollBack {
+						s.Delete(k)
+					}
+					return fmt.Errorf("appending to WAL: %w", err)
+				}
+			}
+			return nil
+		}); err != nil {
+			metrics.addTransactionRolledBack()
+			return err
+		}
+
+		metrics.addKVPairsStored(len(chunk))
+		for _, d := range chunk {
+			metrics.addBytesIngested(int64(len(d.Ciphertext)))
+		}
+		o.uploads.advance(uploadID, len(chunk))
+
+		persisted = append(persisted, chunk...)
+		chunk = make([]Data, 0)
+		chunkBytes = 0
+		return nil
+	}
+
 	j := json.NewDecoder(stream)
 	for {
 		var kv map[string][]byte
-		if err := j.Decode(&kv); err == nil {
-			// happy path
-			for key, value := range kv {
-				// store each received KV pair into the db
-				transaction = append(transaction, Data{
-					Key:        key,
-					Ciphertext: value,
-				})
-			}
-		} else if err == io.EOF {
-			// done
+		err := j.Decode(&kv)
+		if err == io.EOF {
 			break
-		} else {
-			// not so happy path
-			return nil, err // return 400 Bad Request
 		}
-	}
-	// Store using a locked AnyStore, and rollback any stored data in
-	// case of error.
-	if err := a.Run(func(s anystore.AnyStore) error {
-		keysToRollBack := make([]string, 0)
-		for i := range transaction {
-			key := RandomStamp()
-			for {
-				if s.HasKey(key) {
-					key = RandomStamp()
-				} else {
-					break
-				}
+		if err != nil {
+			metrics.addDecodeError()
+			return persisted, persistedCount(), err // return 400 Bad Request
+		}
+		// Go randomizes map iteration order per call, but the resumable
+		// skip/resume logic below relies on visiting a retried upload's
+		// pairs in the same order every time (so skipped counts line up
+		// with the keys actually persisted last time). Sorting keys
+		// makes that order deterministic across a PUT and its retries,
+		// regardless of how many keys one JSON object carries.
+		keys := make([]string, 0, len(kv))
+		for key := range kv {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := kv[key]
+			if skipped < toSkip {
+				skipped++
+				continue
 			}
-			if err := s.Store(key, transaction[i]); err != nil {
-				for _, k := range keysToRollBack {
-					s.Delete(k)
+			chunk = append(chunk, Data{Key: key, Ciphertext: value})
+			chunkBytes += int64(len(value))
+			if chunked && ((maxPairs > 0 && len(chunk) >= maxPairs) || (maxBytes > 0 && chunkBytes >= maxBytes)) {
+				if err := commitChunk(); err != nil {
+					return persisted, persistedCount(), err
 				}
-				return err
 			}
-			keysToRollBack = append(keysToRollBack, key)
 		}
-		return nil
-	}); err != nil {
-		return nil, err
+	}
+	if err := commitChunk(); err != nil {
+		return persisted, persistedCount(), err
 	}
 
-	return transaction, nil
+	return persisted, persistedCount(), nil
 }
 
 func ListenAndServe(customListener net.Listener, srv *http.Server) error {
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sa6mwa/anystore"
+	"github.com/sa6mwa/kryptografpersister/internal/pkg/crand"
+)
+
+// standbyReconnectDelay is how long runStandby waits before retrying
+// a dropped or refused connection to the primary.
+const standbyReconnectDelay = 2 * time.Second
+
+// standbyStateFile is the name of the file a standby persists its
+// replication progress to, inside the directory passed to
+// WithStandby.
+const standbyStateFile = "standby.state"
+
+// WithStandby puts the server into standby mode: instead of serving
+// writes locally, it streams the primary's WAL from primaryURL (the
+// base URL of a primary started with WithWAL), authenticating with
+// psk if non-empty, and applies records into its own AnyStore,
+// reconnecting with the last applied LSN whenever the connection to
+// the primary drops. stateDir is where the standby persists its
+// follower id and last-applied LSN/checksum, so it resumes instead
+// of re-streaming the whole WAL after a restart; it is created if it
+// does not already exist. PUT requests against a standby are
+// rejected; write to the primary instead.
+func WithStandby(primaryURL, psk, stateDir string) Option {
+	return func(o *options) error {
+		if stateDir == "" {
+			return fmt.Errorf("stateDir must not be empty")
+		}
+		if err := os.MkdirAll(stateDir, 0o755); err != nil {
+			return fmt.Errorf("creating standby state directory %q: %w", stateDir, err)
+		}
+		o.standbyPrimaryURL = primaryURL
+		o.standbyPSK = psk
+		o.standbyWALDir = stateDir
+		return nil
+	}
+}
+
+// standbyState is the JSON persisted to standbyStateFile: the
+// follower id this standby identifies itself as when acking applied
+// LSNs back to the primary (see WAL.Ack), and the LSN/checksum of
+// the last WAL record successfully applied.
+type standbyState struct {
+	FollowerID string `json:"followerId"`
+	LSN        uint64 `json:"lsn"`
+	CRC        uint32 `json:"crc"`
+}
+
+// loadStandbyState reads the persisted standbyState from dir,
+// generating and persisting a new follower id if no state file
+// exists yet.
+func loadStandbyState(dir string) (standbyState, error) {
+	b, err := os.ReadFile(filepath.Join(dir, standbyStateFile))
+	if os.IsNotExist(err) {
+		st := standbyState{FollowerID: fmt.Sprintf("%x", crand.Int63())}
+		return st, saveStandbyState(dir, st)
+	}
+	if err != nil {
+		return standbyState{}, err
+	}
+	var st standbyState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return standbyState{}, fmt.Errorf("parsing standby state file: %w", err)
+	}
+	return st, nil
+}
+
+// saveStandbyState persists st to dir, replacing any previous state
+// atomically via rename.
+func saveStandbyState(dir string, st standbyState) error {
+	path := filepath.Join(dir, standbyStateFile)
+	tmp := path + ".tmp"
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runStandby streams WAL records from o.standbyPrimaryURL into
+// anyStore until ctx is cancelled, resuming from the LSN/checksum
+// persisted in o.standbyWALDir and acking its progress back to the
+// primary each time a stream from the primary ends (a followed stream
+// ends only when the connection drops or a record fails to verify or
+// apply). Start runs it as a goroutine
+// when standby mode is configured.
+func runStandby(ctx context.Context, o *options, anyStore anystore.AnyStore, l Logger) {
+	st, err := loadStandbyState(o.standbyWALDir)
+	if err != nil {
+		l.Printf("standby: loading replication state: %v", err)
+		return
+	}
+	client := &http.Client{}
+	for ctx.Err() == nil {
+		url := fmt.Sprintf("%s/wal?from=%d&follow=1", o.standbyPrimaryURL, st.LSN)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			l.Printf("standby: building request for %q: %v", url, err)
+			return
+		}
+		if o.standbyPSK != "" {
+			req.Header.Set(AuthorizationHeader, BearerPrefix+o.standbyPSK)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			l.Printf("standby: connecting to primary %q: %v", o.standbyPrimaryURL, err)
+			sleepOrDone(ctx, standbyReconnectDelay)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			l.Printf("standby: GET %s: unexpected status %d", url, resp.StatusCode)
+			sleepOrDone(ctx, standbyReconnectDelay)
+			continue
+		}
+		st = applyStream(bufio.NewReader(resp.Body), st, anyStore, o.standbyWALDir, l)
+		resp.Body.Close()
+		ackStandby(ctx, client, o.standbyPrimaryURL, o.standbyPSK, st, l)
+		sleepOrDone(ctx, standbyReconnectDelay)
+	}
+}
+
+// applyStream reads length-prefixed WAL frames from br, verifying
+// each record's Checksum chains from st's before applying it, and
+// persists the advancing state to stateDir as it goes. It returns
+// the last state successfully applied; a checksum mismatch, decode
+// error or apply failure stops at the last good record so runStandby
+// reconnects and retries from there.
+func applyStream(br *bufio.Reader, st standbyState, anyStore anystore.AnyStore, stateDir string, l Logger) standbyState {
+	for {
+		rec, err := readFrame(br)
+		if err != nil {
+			return st
+		}
+		if rec.LSN <= st.LSN {
+			continue
+		}
+		expected, err := checksumFor(st.CRC, rec.Entries)
+		if err != nil {
+			l.Printf("standby: computing checksum for WAL record %d: %v", rec.LSN, err)
+			return st
+		}
+		if expected != rec.Checksum {
+			l.Printf("standby: checksum mismatch at WAL record %d: torn write or gap in stream, reconnecting", rec.LSN)
+			return st
+		}
+		if err := applyRecord(anyStore, rec); err != nil {
+			l.Printf("standby: applying WAL record %d: %v", rec.LSN, err)
+			return st
+		}
+		st = standbyState{FollowerID: st.FollowerID, LSN: rec.LSN, CRC: rec.Checksum}
+		if err := saveStandbyState(stateDir, st); err != nil {
+			l.Printf("standby: persisting replication state: %v", err)
+			return st
+		}
+	}
+}
+
+// applyRecord applies every Data entry of rec: tombstones (Deleted)
+// remove StoreKey, everything else is stored under it, mirroring the
+// key the primary committed it under.
+func applyRecord(a anystore.AnyStore, rec WALRecord) error {
+	return a.Run(func(s anystore.AnyStore) error {
+		for _, d := range rec.Entries {
+			if d.Deleted {
+				if err := s.Delete(d.StoreKey); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.Store(d.StoreKey, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ackStandby POSTs st to the primary's /wal/ack so it can track the
+// minimum LSN acknowledged across every known follower and
+// checkpoint its WAL. Errors are logged and otherwise ignored: a
+// missed ack only delays checkpointing, it never loses data.
+func ackStandby(ctx context.Context, client *http.Client, primaryURL, psk string, st standbyState, l Logger) {
+	if st.LSN == 0 {
+		return
+	}
+	body, err := json.Marshal(WALAck{Follower: st.FollowerID, LSN: st.LSN})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, primaryURL+"/wal/ack", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set(ContentTypeHeader, ApplicationJSON)
+	if psk != "" {
+		req.Header.Set(AuthorizationHeader, BearerPrefix+psk)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		l.Printf("standby: acking LSN %d to primary: %v", st.LSN, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func sleepOrDone(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStandbyReplicatesAndPersistsProgress(t *testing.T) {
+	walDir := t.TempDir()
+	primaryURL := startTestPersister(t, WithWAL(walDir))
+
+	if resp := putKV(t, primaryURL, map[string][]byte{"key1": []byte("hello")}, nil); resp.StatusCode != 200 {
+		t.Fatalf("expected 200 from primary PUT, got %d", resp.StatusCode)
+	}
+
+	stateDir := t.TempDir()
+	standbyURL := startTestPersister(t, WithStandby(primaryURL, "", stateDir))
+
+	deadline := time.Now().Add(5 * time.Second)
+	var got map[string][]byte
+	for time.Now().Before(deadline) {
+		got = loadAll(t, standbyURL)
+		if len(got) == 1 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected standby to replicate 1 key within the deadline, got %d", len(got))
+	}
+	if string(got["key1"]) != "hello" {
+		t.Errorf("expected replicated value %q, got %q", "hello", got["key1"])
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	var st standbyState
+	for time.Now().Before(deadline) {
+		var err error
+		st, err = loadStandbyState(stateDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.LSN == 1 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if st.LSN != 1 {
+		t.Fatalf("expected standby to persist LSN 1 to its state file, got %d", st.LSN)
+	}
+	if st.FollowerID == "" {
+		t.Error("expected standby to persist a non-empty follower id")
+	}
+}
+
+func TestStandbyRejectsWrites(t *testing.T) {
+	walDir := t.TempDir()
+	primaryURL := startTestPersister(t, WithWAL(walDir))
+	stateDir := t.TempDir()
+	standbyURL := startTestPersister(t, WithStandby(primaryURL, "", stateDir))
+
+	resp := putKV(t, standbyURL, map[string][]byte{"key1": []byte("hello")}, nil)
+	if got, expected := resp.StatusCode, 503; got != expected {
+		t.Errorf("expected standby PUT to be rejected with %d, got %d", expected, got)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// startTestPersister starts a persister rooted at a fresh AnyStore DB
+// file under t.TempDir(), applying opts, and returns its base URL.
+// Start is shut down via t.Cleanup once the test finishes.
+func startTestPersister(t *testing.T, opts ...Option) string {
+	t.Helper()
+	dbFile := filepath.Join(t.TempDir(), "persistence.db")
+	returnCh, terminator, listenAddr, err := Start(proto, addr, dbFile, encryptionKey, nil, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(terminator)
+		if err := <-returnCh; err != nil {
+			t.Error(err)
+		}
+	})
+	return "http://" + *listenAddr
+}
+
+// putKV issues a PUT to kurl with pairs json-encoded as the body and
+// headers (e.g. UploadIDHeader) set on the request.
+func putKV(t *testing.T, kurl string, pairs map[string][]byte, headers map[string]string) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, kurl, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// loadAll GETs kurl and decodes every newline-delimited
+// map[string][]byte object in the response into a single map keyed
+// by Data.Key.
+func loadAll(t *testing.T, kurl string) map[string][]byte {
+	t.Helper()
+	resp, err := http.Get(kurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got := make(map[string][]byte)
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var kv map[string][]byte
+		if err := dec.Decode(&kv); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range kv {
+			got[k] = v
+		}
+	}
+	return got
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Transport abstracts how Start accepts connections and configures
+// the *http.Server that serves them, so the persister can run over
+// plain TCP, TLS, or TLS with HTTP/2 multiplexing without Start
+// itself knowing the difference.
+type Transport interface {
+	// Listen opens the listening socket for proto/addr, wrapping it
+	// (e.g. in TLS) as required by the transport.
+	Listen(proto, addr string) (net.Listener, error)
+	// ConfigureServer applies any required configuration (e.g.
+	// srv.TLSConfig, HTTP/2) to srv before it starts serving. It is
+	// called once, before Listen.
+	ConfigureServer(srv *http.Server) error
+}
+
+// PlainTransport is the default Transport: plaintext TCP, no TLS, no
+// HTTP/2 multiplexing. It is equivalent to Start's behaviour before
+// Transport existed.
+type PlainTransport struct{}
+
+func (PlainTransport) Listen(proto, addr string) (net.Listener, error) {
+	return net.Listen(proto, addr)
+}
+
+func (PlainTransport) ConfigureServer(*http.Server) error { return nil }
+
+// TLSTransport serves HTTPS, optionally with mutual TLS and/or
+// HTTP/2. If CertFile/KeyFile are both empty, ConfigureServer
+// generates a throwaway self-signed certificate so the transport is
+// still usable for local development.
+type TLSTransport struct {
+	// CertFile and KeyFile are PEM paths for the server's own
+	// certificate. Leave both empty to auto-generate a self-signed
+	// certificate for development use.
+	CertFile, KeyFile string
+	// ClientCAFile, if set, enables mutual TLS: only clients
+	// presenting a certificate signed by a CA in this PEM file are
+	// accepted.
+	ClientCAFile string
+	// HTTP2 enables explicit HTTP/2 support via
+	// golang.org/x/net/http2.ConfigureServer, benefiting large
+	// streaming LoadAll responses.
+	HTTP2 bool
+
+	tlsConfig *tls.Config
+}
+
+func (t *TLSTransport) ConfigureServer(srv *http.Server) error {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if t.CertFile == "" && t.KeyFile == "" {
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			return fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	} else {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading client CA file %q: %w", t.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %q", t.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.tlsConfig = cfg
+	srv.TLSConfig = cfg
+
+	if t.HTTP2 {
+		if err := http2.ConfigureServer(srv, &http2.Server{}); err != nil {
+			return fmt.Errorf("configuring HTTP/2: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *TLSTransport) Listen(proto, addr string) (net.Listener, error) {
+	if t.tlsConfig == nil {
+		return nil, fmt.Errorf("TLSTransport.Listen called before ConfigureServer")
+	}
+	ln, err := net.Listen(proto, addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, t.tlsConfig), nil
+}
+
+// generateSelfSignedCert returns a throwaway ECDSA self-signed
+// certificate valid for localhost, for development use only.
+func generateSelfSignedCert() (tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "kryptografpersister dev"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -0,0 +1,469 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// WALSegmentFile is the name of the single active WAL segment
+	// inside a WAL directory. Once checkpointed, old records are
+	// pruned from the front of this file rather than rotated into
+	// numbered segments, keeping the on-disk format simple.
+	WALSegmentFile string = "wal.log"
+)
+
+// WALRecord is a single append-only entry in the WAL, written once
+// per StoreJsonKV transaction before it is committed to the
+// AnyStore. Checksum is a rolling crc32 computed over the JSON
+// encoding of Entries chained with the previous record's checksum,
+// so a standby can detect a torn write or gap in the stream.
+type WALRecord struct {
+	LSN       uint64    `json:"lsn"`
+	Timestamp time.Time `json:"timestamp"`
+	Entries   []Data    `json:"entries"`
+	Checksum  uint32    `json:"checksum"`
+}
+
+// WAL is an append-only write-ahead log recording StoreJsonKV
+// transactions so they can be streamed to standby persisters via the
+// /wal endpoint. A WAL is safe for concurrent use.
+type WAL struct {
+	mu          sync.Mutex
+	dir         string
+	file        *os.File
+	lastLSN     uint64
+	lastCRC     uint32
+	subscribers map[chan WALRecord]struct{}
+	acked       map[string]uint64 // per-follower last acked LSN, see Ack
+}
+
+// OpenWAL opens (creating if necessary) the WAL segment in dir,
+// replaying it to recover the last LSN and rolling checksum.
+func OpenWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating WAL directory %q: %w", dir, err)
+	}
+	path := filepath.Join(dir, WALSegmentFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening WAL segment %q: %w", path, err)
+	}
+	w := &WAL{
+		dir:         dir,
+		file:        f,
+		subscribers: make(map[chan WALRecord]struct{}),
+	}
+	if err := w.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// replay reads every record already on disk, verifying each one's
+// Checksum against the previous record's before trusting it, to
+// recover lastLSN and lastCRC. Called once from OpenWAL while no
+// writers or readers can yet observe w.
+func (w *WAL) replay() error {
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.file)
+	for {
+		rec, err := readFrame(r)
+		if err == errNoMoreFrames {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("replaying WAL: %w", err)
+		}
+		expected, err := checksumFor(w.lastCRC, rec.Entries)
+		if err != nil {
+			return fmt.Errorf("replaying WAL: computing checksum for record %d: %w", rec.LSN, err)
+		}
+		if expected != rec.Checksum {
+			return fmt.Errorf("replaying WAL: checksum mismatch at record %d: torn write or corrupt segment", rec.LSN)
+		}
+		w.lastLSN = rec.LSN
+		w.lastCRC = rec.Checksum
+	}
+	if _, err := w.file.Seek(0, 2); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checksumFor returns the rolling checksum for entries once chained
+// after prevCRC: the computation Append uses to produce a new
+// WALRecord's Checksum, and replay and a replicator's apply use to
+// verify a record they read wasn't torn or preceded by a gap in the
+// stream.
+func checksumFor(prevCRC uint32, entries []Data) (uint32, error) {
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.Update(prevCRC, crc32.IEEETable, payload), nil
+}
+
+// Append assigns the next LSN to entries, writes the record as a
+// length-prefixed frame, fsyncs it, and fans it out to any active
+// subscribers. Append must be called before the corresponding
+// AnyStore transaction commits, so a standby can never observe a
+// mutation the WAL does not know about.
+func (w *WAL) Append(entries []Data) (WALRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	checksum, err := checksumFor(w.lastCRC, entries)
+	if err != nil {
+		return WALRecord{}, err
+	}
+	rec := WALRecord{
+		LSN:       w.lastLSN + 1,
+		Timestamp: time.Now().UTC(),
+		Entries:   entries,
+		Checksum:  checksum,
+	}
+	if err := writeFrame(w.file, rec); err != nil {
+		return WALRecord{}, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return WALRecord{}, err
+	}
+	w.lastLSN = rec.LSN
+	w.lastCRC = rec.Checksum
+	for ch := range w.subscribers {
+		select {
+		case ch <- rec:
+		default:
+			// slow subscriber: drop rather than block the primary, it
+			// will fall back to reading /wal?from=<lsn> to catch up.
+		}
+	}
+	return rec, nil
+}
+
+// Subscribe registers a channel that receives every record appended
+// from now on. The returned cancel func must be called once the
+// subscriber is done to avoid leaking the channel.
+func (w *WAL) Subscribe() (<-chan WALRecord, func()) {
+	ch := make(chan WALRecord, 64)
+	w.mu.Lock()
+	w.subscribers[ch] = struct{}{}
+	w.mu.Unlock()
+	cancel := func() {
+		w.mu.Lock()
+		delete(w.subscribers, ch)
+		w.mu.Unlock()
+		close(ch)
+	}
+	return ch, cancel
+}
+
+// Since returns every record with LSN strictly greater than from, in
+// LSN order, by scanning the on-disk segment.
+func (w *WAL) Since(from uint64) ([]WALRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	defer w.file.Seek(0, 2)
+
+	records := make([]WALRecord, 0)
+	r := bufio.NewReader(w.file)
+	for {
+		rec, err := readFrame(r)
+		if err == errNoMoreFrames {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.LSN > from {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// LastLSN returns the LSN of the most recently appended record, or 0
+// if the WAL is empty.
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastLSN
+}
+
+// Ack records that follower has applied up to lsn, then checkpoints
+// the WAL up to the minimum LSN acknowledged across every follower
+// that has ever acked, so the segment only grows as far back as the
+// slowest known standby still needs.
+func (w *WAL) Ack(follower string, lsn uint64) error {
+	w.mu.Lock()
+	if w.acked == nil {
+		w.acked = make(map[string]uint64)
+	}
+	if cur, ok := w.acked[follower]; !ok || lsn > cur {
+		w.acked[follower] = lsn
+	}
+	min := lsn
+	for _, acked := range w.acked {
+		if acked < min {
+			min = acked
+		}
+	}
+	w.mu.Unlock()
+	return w.Checkpoint(min)
+}
+
+// Checkpoint discards every record with LSN <= ackedLSN by rewriting
+// the segment with only the records after it, once the caller has
+// confirmed all known followers have applied up to ackedLSN.
+func (w *WAL) Checkpoint(ackedLSN uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.file)
+	kept := make([]WALRecord, 0)
+	for {
+		rec, err := readFrame(r)
+		if err == errNoMoreFrames {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("checkpointing WAL: %w", err)
+		}
+		if rec.LSN > ackedLSN {
+			kept = append(kept, rec)
+		}
+	}
+
+	tmpPath := filepath.Join(w.dir, WALSegmentFile+".checkpoint")
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, rec := range kept {
+		if err := writeFrame(tmp, rec); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	tmp.Close()
+	w.file.Close()
+	if err := os.Rename(tmpPath, filepath.Join(w.dir, WALSegmentFile)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(w.dir, WALSegmentFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, 2); err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	return nil
+}
+
+// Close releases the WAL's underlying file handle.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// handleWAL serves GET /wal?from=<lsn>&follow=1: it writes every
+// length-prefixed WALRecord frame with LSN > from, then, if follow is
+// set, keeps the connection open and streams new records as they are
+// appended until the client disconnects. This is the transport
+// runStandby speaks to catch up and then tail the primary.
+func handleWAL(wal *WAL, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("%d %s", http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))}))
+		return
+	}
+
+	from, err := parseLSN(r.URL.Query().Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("invalid from parameter: %v", err)}))
+		return
+	}
+	follow := r.URL.Query().Get("follow") == "1"
+
+	backlog, err := wal.Since(from)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("reading WAL: %v", err)}))
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+	for _, rec := range backlog {
+		if err := writeRecordFrame(w, rec); err != nil {
+			return
+		}
+		from = rec.LSN
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	if !follow {
+		return
+	}
+
+	ch, cancel := wal.Subscribe()
+	defer cancel()
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rec, ok := <-ch:
+			if !ok {
+				return
+			}
+			if rec.LSN <= from {
+				continue
+			}
+			if err := writeRecordFrame(w, rec); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// WALAck is the JSON body POSTed to /wal/ack by a standby once it
+// has durably applied every record up to LSN, identifying itself as
+// Follower so the primary can track the minimum LSN acknowledged
+// across every known follower (see WAL.Ack).
+type WALAck struct {
+	Follower string `json:"follower"`
+	LSN      uint64 `json:"lsn"`
+}
+
+// handleWALAck serves POST /wal/ack: it decodes a WALAck from the
+// request body and calls wal.Ack, checkpointing the WAL once every
+// known follower has acknowledged past a given LSN.
+func handleWALAck(wal *WAL, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("%d %s", http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))}))
+		return
+	}
+	var ack WALAck
+	if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("invalid ack body: %v", err)}))
+		return
+	}
+	if ack.Follower == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ToJson(&Msg{Msg: "ack must identify a follower"}))
+		return
+	}
+	if err := wal.Ack(ack.Follower, ack.LSN); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(ToJson(&Msg{Msg: fmt.Sprintf("checkpointing WAL: %v", err)}))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(ToJson(&Msg{Msg: "OK"}))
+}
+
+// writeRecordFrame writes rec to w using the same length-prefixed
+// JSON framing as the on-disk WAL segment, so a standby's applyStream
+// reads /wal responses with the same readFrame used for segment files.
+func writeRecordFrame(w http.ResponseWriter, rec WALRecord) error {
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(j)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(j)
+	return err
+}
+
+// parseLSN parses the from query parameter, treating an empty string
+// as LSN 0 (stream from the beginning of the WAL).
+func parseLSN(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+var errNoMoreFrames = fmt.Errorf("no more WAL frames")
+
+// writeFrame writes rec as a 4-byte big-endian length prefix followed
+// by its JSON encoding, the format streamed by GET /wal and read back
+// by readFrame.
+func writeFrame(w *os.File, rec WALRecord) error {
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(j)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(j)
+	return err
+}
+
+// readFrame reads one length-prefixed WALRecord frame from r,
+// returning errNoMoreFrames once r is exhausted cleanly between
+// frames.
+func readFrame(r *bufio.Reader) (WALRecord, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		if err == io.EOF {
+			return WALRecord{}, errNoMoreFrames
+		}
+		return WALRecord{}, fmt.Errorf("truncated WAL frame length prefix: %w", err)
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return WALRecord{}, fmt.Errorf("truncated WAL frame: %w", err)
+	}
+	var rec WALRecord
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return WALRecord{}, err
+	}
+	return rec, nil
+}